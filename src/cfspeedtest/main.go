@@ -5,9 +5,13 @@ import (
 	"encoding/json"
 	"flag"
 	"fmt"
+	"net"
 	"os"
+	"path/filepath"
+	"strings"
 	"time"
 
+	"github.com/Ozark-Connect/NetworkOptimizer/src/cfspeedtest/report"
 	"github.com/Ozark-Connect/NetworkOptimizer/src/cfspeedtest/speedtest"
 )
 
@@ -24,6 +28,18 @@ func main() {
 	uploadOnly := flag.Bool("upload-only", false, "Skip download")
 	timeout := flag.Int("timeout", int(cfg.Timeout.Seconds()), "Overall timeout seconds")
 	iface := flag.String("interface", "", "Network interface to bind to (e.g. eth2)")
+	serve := flag.String("serve", "", "Run as a mesh node, serving /__down, /__up and /__mesh/run on this address (e.g. :7000)")
+	peers := flag.String("peers", "", "Comma-separated host:port list of mesh peers to test against (coordinator mode)")
+	meshListen := flag.String("mesh-listen", "", "While coordinating a mesh test (--peers), also serve mesh endpoints on this address so peers can test the reverse edge back to this node. Must include a host peers can actually dial (e.g. 192.168.1.5:7000); a bare port like \":7000\" is rejected, since peers would resolve it on their own machine, not this one")
+	serverList := flag.Bool("server-list", false, "Probe candidate servers, print them ranked by score, and exit (the Cloudflare edge is the only built-in candidate; pass --custom-url to give it something to rank against)")
+	server := flag.String("server", "", "Force a specific throughput server URL instead of auto-selecting")
+	customURL := flag.String("custom-url", "", "Comma-separated custom throughput endpoints to include in server selection")
+	multi := flag.Bool("multi", false, "Run the throughput phase against the top-K selected servers concurrently (meaningful only with --custom-url; otherwise there is one candidate)")
+	multiServers := flag.Int("multi-servers", 3, "Number of top-ranked servers to use with --multi")
+	adaptiveStreams := flag.Bool("adaptive-streams", false, "Ramp concurrency from 1 stream to find the minimum needed to saturate the path")
+	pcapPath := flag.String("pcap", "", "Capture traffic during the throughput phases and write a per-connection diagnostic report to this path")
+	live := flag.Bool("live", false, "Render a rolling live progress display (Mbps, latency percentiles) to stderr while the test runs")
+	samplesPath := flag.String("samples", "", "Stream one NDJSON record per 200ms sample and per completed HTTP request to this path, for time-series post-processing")
 	showVersion := flag.Bool("version", false, "Print version")
 
 	flag.Parse()
@@ -33,6 +49,16 @@ func main() {
 		os.Exit(0)
 	}
 
+	if *serve != "" {
+		cfg.Interface = *iface
+		fmt.Fprintf(os.Stderr, "Serving mesh endpoints on %s\n", *serve)
+		if err := speedtest.ServeMesh(*serve, cfg); err != nil {
+			fmt.Fprintf(os.Stderr, "serve: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	cfg.Streams = *streams
 	cfg.Duration = time.Duration(*duration) * time.Second
 	cfg.DownloadSize = *downloadSize
@@ -41,8 +67,36 @@ func main() {
 	cfg.UploadOnly = *uploadOnly
 	cfg.Timeout = time.Duration(*timeout) * time.Second
 	cfg.Interface = *iface
+	cfg.AdaptiveStreams = *adaptiveStreams
+	cfg.PcapPath = *pcapPath
+	cfg.SamplesOutput = *samplesPath
+
+	if *serverList {
+		runServerList(cfg, *customURL)
+		return
+	}
 
-	result := run(cfg)
+	if *server != "" {
+		cfg.BaseURL = *server
+	}
+
+	if *meshListen != "" {
+		host, _, err := net.SplitHostPort(*meshListen)
+		if err != nil || host == "" {
+			fmt.Fprintf(os.Stderr, "--mesh-listen %q must include a host peers can dial (e.g. 192.168.1.5:7000), not just a port\n", *meshListen)
+			os.Exit(1)
+		}
+	}
+
+	var result speedtest.Result
+	switch {
+	case *peers != "":
+		result = runMesh(cfg, strings.Split(*peers, ","), *meshListen)
+	case *multi:
+		result = runMulti(cfg, *customURL, *multiServers)
+	default:
+		result = run(cfg, *live)
+	}
 
 	enc := json.NewEncoder(os.Stdout)
 	enc.SetIndent("", "  ")
@@ -56,7 +110,7 @@ func main() {
 	}
 }
 
-func run(cfg speedtest.Config) speedtest.Result {
+func run(cfg speedtest.Config, live bool) speedtest.Result {
 	ctx, cancel := context.WithTimeout(context.Background(), cfg.Timeout)
 	defer cancel()
 
@@ -89,27 +143,56 @@ func run(cfg speedtest.Config) speedtest.Result {
 	}
 	result.Latency = latency
 	fmt.Fprintf(os.Stderr, "Latency: %.1f ms (jitter: %.1f ms)\n", latency.UnloadedMs, latency.JitterMs)
+	cfg.UnloadedLatencyMs = latency.UnloadedMs
 
 	// Phase 3: Download
 	if !cfg.UploadOnly {
 		fmt.Fprintf(os.Stderr, "Testing download (%d streams, %ds)...\n", cfg.Streams, int(cfg.Duration.Seconds()))
-		dl, err := speedtest.MeasureThroughput(ctx, false, cfg)
+		downloadCfg := cfg
+		downloadCfg.PcapPath = phaseOutputPath(cfg.PcapPath, "download")
+		downloadCfg.SamplesOutput = phaseOutputPath(cfg.SamplesOutput, "download")
+		var downloadLive *report.LiveRenderer
+		if live {
+			downloadLive = report.NewLiveRenderer(os.Stderr, cfg.Duration)
+			downloadCfg.ProgressSink = downloadLive.Record
+		}
+		dl, err := speedtest.MeasureThroughput(ctx, false, downloadCfg)
+		if downloadLive != nil {
+			downloadLive.Finish()
+		}
 		if err != nil {
 			return errorResult("download: " + err.Error())
 		}
 		result.Download = dl
 		fmt.Fprintf(os.Stderr, "Download: %.1f Mbps\n", dl.Bps/1_000_000)
+		if dl.AdaptiveStreams {
+			fmt.Fprintf(os.Stderr, "Adaptive streams settled at %d (knee at %d)\n", dl.ChosenStreams, dl.KneeStreams)
+		}
 	}
 
 	// Phase 4: Upload
 	if !cfg.DownloadOnly {
 		fmt.Fprintf(os.Stderr, "Testing upload (%d streams, %ds)...\n", cfg.Streams, int(cfg.Duration.Seconds()))
-		ul, err := speedtest.MeasureThroughput(ctx, true, cfg)
+		uploadCfg := cfg
+		uploadCfg.PcapPath = phaseOutputPath(cfg.PcapPath, "upload")
+		uploadCfg.SamplesOutput = phaseOutputPath(cfg.SamplesOutput, "upload")
+		var uploadLive *report.LiveRenderer
+		if live {
+			uploadLive = report.NewLiveRenderer(os.Stderr, cfg.Duration)
+			uploadCfg.ProgressSink = uploadLive.Record
+		}
+		ul, err := speedtest.MeasureThroughput(ctx, true, uploadCfg)
+		if uploadLive != nil {
+			uploadLive.Finish()
+		}
 		if err != nil {
 			return errorResult("upload: " + err.Error())
 		}
 		result.Upload = ul
 		fmt.Fprintf(os.Stderr, "Upload: %.1f Mbps\n", ul.Bps/1_000_000)
+		if ul.AdaptiveStreams {
+			fmt.Fprintf(os.Stderr, "Adaptive streams settled at %d (knee at %d)\n", ul.ChosenStreams, ul.KneeStreams)
+		}
 	}
 
 	result.Success = true
@@ -122,6 +205,124 @@ func run(cfg speedtest.Config) speedtest.Result {
 	return result
 }
 
+// runMesh coordinates a mesh throughput test against the given peers,
+// treating the local machine as one of the mesh nodes. If listen is set,
+// this node also serves mesh endpoints on it (peers dial it by that
+// address) so the peer-to-coordinator edges can be measured too; otherwise
+// the coordinator only measures its own edges out to each peer, and no peer
+// measures an edge back to it, since it isn't listening for a peer to dial.
+func runMesh(cfg speedtest.Config, peers []string, listen string) speedtest.Result {
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Timeout)
+	defer cancel()
+
+	self := "local"
+	selfServing := listen != ""
+	if selfServing {
+		self = listen
+		go func() {
+			if err := speedtest.ServeMesh(listen, cfg); err != nil {
+				fmt.Fprintf(os.Stderr, "mesh-listen %s: %v\n", listen, err)
+			}
+		}()
+	}
+
+	fmt.Fprintf(os.Stderr, "Running mesh test against %d peer(s)...\n", len(peers))
+	mesh, err := speedtest.MeasureMesh(ctx, self, peers, cfg, selfServing)
+	if err != nil {
+		return errorResult("mesh: " + err.Error())
+	}
+
+	for _, edge := range mesh.Edges {
+		if edge.Error != "" {
+			fmt.Fprintf(os.Stderr, "%s -> %s (%s): error: %s\n", edge.From, edge.To, edge.Direction, edge.Error)
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "%s -> %s (%s): %.1f Mbps (latency %.1f ms)\n", edge.From, edge.To, edge.Direction, edge.Bps/1_000_000, edge.LoadedLatencyMs)
+	}
+
+	return speedtest.Result{
+		Success:   true,
+		Mesh:      mesh,
+		Timestamp: time.Now().UTC(),
+	}
+}
+
+// runServerList probes the candidate servers, prints them ranked by score,
+// and exits. Used by --server-list to inspect selection without running a
+// full throughput test.
+func runServerList(cfg speedtest.Config, customURL string) {
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Timeout)
+	defer cancel()
+
+	if _, err := speedtest.NewClient(cfg, 30*time.Second); err != nil {
+		fmt.Fprintf(os.Stderr, "bind interface: %v\n", err)
+		os.Exit(1)
+	}
+
+	candidates := speedtest.DefaultCandidates(customURL)
+	scores := speedtest.SelectServer(ctx, candidates, cfg)
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(scores)
+}
+
+// runMulti selects the top-K candidate servers and runs the throughput
+// phase against all of them concurrently, aggregating per-server results
+// into Result.Servers.
+func runMulti(cfg speedtest.Config, customURL string, topK int) speedtest.Result {
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Timeout)
+	defer cancel()
+
+	client, err := speedtest.NewClient(cfg, 30*time.Second)
+	if err != nil {
+		return errorResult("bind interface: " + err.Error())
+	}
+
+	result := speedtest.Result{Timestamp: time.Now().UTC()}
+
+	meta, err := speedtest.FetchMetadata(ctx, client)
+	if err != nil {
+		return errorResult("metadata: " + err.Error())
+	}
+	result.Metadata = meta
+
+	candidates := speedtest.DefaultCandidates(customURL)
+	scores := speedtest.SelectServer(ctx, candidates, cfg)
+	if topK < 0 {
+		topK = 0
+	}
+	if topK > len(scores) {
+		topK = len(scores)
+	}
+
+	top := make([]speedtest.ServerCandidate, 0, topK)
+	for _, s := range scores[:topK] {
+		fmt.Fprintf(os.Stderr, "Selected %s (score %.1f, rtt %.1f ms)\n", s.Candidate.Name, s.Score, s.RTTMs)
+		top = append(top, s.Candidate)
+	}
+
+	fmt.Fprintf(os.Stderr, "Testing %d servers concurrently (%d streams each, %ds)...\n", len(top), cfg.Streams, int(cfg.Duration.Seconds()))
+	result.Servers = speedtest.MeasureMultiServer(ctx, top, cfg)
+
+	result.Success = true
+	result.Streams = cfg.Streams
+	result.DurationSeconds = int(cfg.Duration.Seconds())
+	return result
+}
+
+// phaseOutputPath derives a per-phase output path (e.g. "out.json" becomes
+// "out.download.json") so a download-then-upload run doesn't overwrite one
+// phase's file with the other's. Used for both --pcap and --samples.
+// Returns "" unchanged when base is unset.
+func phaseOutputPath(base, phase string) string {
+	if base == "" {
+		return ""
+	}
+	ext := filepath.Ext(base)
+	return strings.TrimSuffix(base, ext) + "." + phase + ext
+}
+
 func errorResult(msg string) speedtest.Result {
 	return speedtest.Result{
 		Success:   false,
@@ -0,0 +1,98 @@
+// Package report renders a rolling live-progress display for a speed test
+// in progress, driven by the speedtest package's 200ms progress ticks. It
+// never touches the JSON result the main program writes to stdout.
+package report
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/Ozark-Connect/NetworkOptimizer/src/cfspeedtest/speedtest"
+)
+
+// LiveRenderer consumes speedtest.ProgressSample ticks and prints a rolling
+// single-line summary: current Mbps and the running mean of every tick so
+// far in the phase, p50/p95/p99 loaded
+// latency, sample count, elapsed/remaining time, and bytes transferred.
+// Percentiles and mean are computed online (P² and Welford) so memory use
+// stays flat regardless of run length.
+type LiveRenderer struct {
+	w        io.Writer
+	total    time.Duration
+	mbps     Welford
+	p50      *P2Estimator
+	p95      *P2Estimator
+	p99      *P2Estimator
+	samples  int
+	lastLine int // width of the last line written, so the next can overwrite it with padding
+}
+
+// NewLiveRenderer returns a renderer that writes to w (typically os.Stderr,
+// to keep stdout free for the JSON result) and expects the phase to run for
+// roughly total.
+func NewLiveRenderer(w io.Writer, total time.Duration) *LiveRenderer {
+	return &LiveRenderer{
+		w:     w,
+		total: total,
+		p50:   NewP2Estimator(0.50),
+		p95:   NewP2Estimator(0.95),
+		p99:   NewP2Estimator(0.99),
+	}
+}
+
+// Record has the signature of speedtest.Config.ProgressSink: call it once per
+// progress tick.
+func (r *LiveRenderer) Record(s speedtest.ProgressSample) {
+	r.samples++
+	r.mbps.Add(s.Mbps)
+	if s.LoadedLatencyMs > 0 {
+		r.p50.Add(s.LoadedLatencyMs)
+		r.p95.Add(s.LoadedLatencyMs)
+		r.p99.Add(s.LoadedLatencyMs)
+	}
+
+	elapsed := time.Duration(s.ElapsedMs) * time.Millisecond
+	remaining := r.total - elapsed
+	if remaining < 0 {
+		remaining = 0
+	}
+
+	line := fmt.Sprintf(
+		"\r%-8s %7.1f Mbps (avg %7.1f)  p50/p95/p99 %5.1f/%5.1f/%5.1f ms  n=%-4d  %4.1fs/%4.1fs  %s",
+		s.Phase, s.Mbps, r.mbps.Mean(),
+		r.p50.Value(), r.p95.Value(), r.p99.Value(),
+		r.samples, elapsed.Seconds(), r.total.Seconds(),
+		humanizeBytes(s.TotalBytes),
+	)
+	r.write(line)
+}
+
+// Finish leaves the last summary line on screen and moves to a fresh line so
+// subsequent stderr output (or the JSON result on stdout) doesn't run into it.
+func (r *LiveRenderer) Finish() {
+	r.write("")
+	fmt.Fprintln(r.w)
+}
+
+func (r *LiveRenderer) write(line string) {
+	if pad := r.lastLine - len(line); pad > 0 {
+		line += fmt.Sprintf("%*s", pad, "")
+	}
+	r.lastLine = len(line)
+	fmt.Fprint(r.w, line)
+}
+
+// humanizeBytes formats n bytes as a short human-readable string (e.g. "12.3 MB").
+func humanizeBytes(n int64) string {
+	const unit = 1000
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for v := n / unit; v >= unit; v /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "kMGTPE"[exp])
+}
@@ -0,0 +1,115 @@
+package report
+
+import "sort"
+
+// P2Estimator implements the P² algorithm (Jain & Chlamtac, 1985) for
+// single-pass, O(1)-per-sample estimation of one target percentile without
+// retaining the samples. Used for the rolling p50/p95/p99 of loaded latency
+// shown by --live, so long-duration runs don't grow memory with sample count.
+type P2Estimator struct {
+	p       float64
+	count   int
+	initial []float64 // buffered until the first 5 samples arrive
+
+	q  [5]float64 // marker heights
+	n  [5]int     // marker positions
+	np [5]float64 // desired marker positions
+	dn [5]float64 // desired position increments
+}
+
+// NewP2Estimator returns an estimator for the given percentile, expressed as
+// a fraction in (0, 1) — e.g. 0.95 for p95.
+func NewP2Estimator(p float64) *P2Estimator {
+	return &P2Estimator{p: p}
+}
+
+// Add folds x into the estimate.
+func (e *P2Estimator) Add(x float64) {
+	e.count++
+
+	if e.count <= 5 {
+		e.initial = append(e.initial, x)
+		if e.count == 5 {
+			sort.Float64s(e.initial)
+			for i := 0; i < 5; i++ {
+				e.q[i] = e.initial[i]
+				e.n[i] = i + 1
+			}
+			e.np = [5]float64{1, 1 + 2*e.p, 1 + 4*e.p, 3 + 2*e.p, 5}
+			e.dn = [5]float64{0, e.p / 2, e.p, (1 + e.p) / 2, 1}
+		}
+		return
+	}
+
+	k := e.findCell(x)
+
+	for i := k + 1; i < 5; i++ {
+		e.n[i]++
+	}
+	for i := 0; i < 5; i++ {
+		e.np[i] += e.dn[i]
+	}
+
+	for i := 1; i < 4; i++ {
+		d := e.np[i] - float64(e.n[i])
+		if (d >= 1 && e.n[i+1]-e.n[i] > 1) || (d <= -1 && e.n[i-1]-e.n[i] < -1) {
+			sign := 1
+			if d < 0 {
+				sign = -1
+			}
+			qNew := e.parabolic(i, sign)
+			if e.q[i-1] < qNew && qNew < e.q[i+1] {
+				e.q[i] = qNew
+			} else {
+				e.q[i] = e.linear(i, sign)
+			}
+			e.n[i] += sign
+		}
+	}
+}
+
+// findCell locates the marker cell containing x, extending the outer
+// markers if x falls outside the current range.
+func (e *P2Estimator) findCell(x float64) int {
+	switch {
+	case x < e.q[0]:
+		e.q[0] = x
+		return 0
+	case x >= e.q[4]:
+		e.q[4] = x
+		return 3
+	}
+	for i := 1; i < 4; i++ {
+		if x < e.q[i] {
+			return i - 1
+		}
+	}
+	return 3
+}
+
+func (e *P2Estimator) parabolic(i, d int) float64 {
+	dd := float64(d)
+	return e.q[i] + dd/float64(e.n[i+1]-e.n[i-1])*
+		((float64(e.n[i]-e.n[i-1])+dd)*(e.q[i+1]-e.q[i])/float64(e.n[i+1]-e.n[i])+
+			(float64(e.n[i+1]-e.n[i])-dd)*(e.q[i]-e.q[i-1])/float64(e.n[i]-e.n[i-1]))
+}
+
+func (e *P2Estimator) linear(i, d int) float64 {
+	return e.q[i] + float64(d)*(e.q[i+d]-e.q[i])/float64(e.n[i+d]-e.n[i])
+}
+
+// Value returns the current percentile estimate, 0 if no samples have been
+// added yet. Before 5 samples have landed it falls back to the buffered
+// sample at rank floor(p*(n-1)) of the n sorted so far, without interpolation.
+func (e *P2Estimator) Value() float64 {
+	if e.count == 0 {
+		return 0
+	}
+	if e.count < 5 {
+		sorted := append([]float64(nil), e.initial...)
+		sort.Float64s(sorted)
+		idx := int(e.p * float64(len(sorted)-1))
+		return sorted[idx]
+	}
+	return e.q[2]
+}
@@ -0,0 +1,43 @@
+package report
+
+import "math"
+
+// Welford computes a running mean and variance in O(1) time and space per
+// sample using Welford's online algorithm, so long-duration runs don't need
+// to retain every sample just to report a mean.
+type Welford struct {
+	count int64
+	mean  float64
+	m2    float64
+}
+
+// Add folds x into the running mean/variance.
+func (w *Welford) Add(x float64) {
+	w.count++
+	delta := x - w.mean
+	w.mean += delta / float64(w.count)
+	w.m2 += delta * (x - w.mean)
+}
+
+// Mean returns the running mean, or 0 if no samples have been added.
+func (w *Welford) Mean() float64 {
+	return w.mean
+}
+
+// Variance returns the running sample variance, or 0 with fewer than 2 samples.
+func (w *Welford) Variance() float64 {
+	if w.count < 2 {
+		return 0
+	}
+	return w.m2 / float64(w.count-1)
+}
+
+// StdDev returns the running sample standard deviation.
+func (w *Welford) StdDev() float64 {
+	return math.Sqrt(w.Variance())
+}
+
+// Count returns how many samples have been added.
+func (w *Welford) Count() int64 {
+	return w.count
+}
@@ -0,0 +1,193 @@
+package speedtest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+)
+
+// meshRunPath is the control-plane endpoint a coordinator POSTs to, asking
+// a node to measure throughput against a set of peers and report back.
+const meshRunPath = "__mesh/run"
+
+// MeshEdge holds the throughput measured over a single directed link
+// between two mesh nodes, in one direction (download or upload).
+type MeshEdge struct {
+	From            string  `json:"from"`
+	To              string  `json:"to"`
+	Direction       string  `json:"direction"` // "download" or "upload"
+	Bps             float64 `json:"bps"`
+	LoadedLatencyMs float64 `json:"loaded_latency_ms"`
+	Error           string  `json:"error,omitempty"`
+}
+
+// MeshResult holds the full matrix of pairwise measurements across a set
+// of mesh nodes. This mirrors the mesh-style netperf pattern used in
+// distributed storage fleets: the goal is to surface a single slow or
+// lossy link, not just aggregate internet egress speed.
+type MeshResult struct {
+	Nodes []string   `json:"nodes"`
+	Edges []MeshEdge `json:"edges"`
+}
+
+// meshRunRequest is the control-plane payload POSTed to a peer's
+// /__mesh/run endpoint, asking it to measure throughput against every
+// other node in the mesh and report the resulting edges.
+type meshRunRequest struct {
+	Self  string   `json:"self"`
+	Peers []string `json:"peers"`
+}
+
+type meshRunResponse struct {
+	Edges []MeshEdge `json:"edges"`
+}
+
+// MeasureMesh coordinates a full mesh throughput test: self measures
+// throughput (both directions) directly against every peer, and every peer
+// is asked (via its /__mesh/run control endpoint, served by ServeMesh) to
+// do the same against every other peer. If selfServing is true, the
+// coordinator is itself reachable by peers at self (ServeMesh is running
+// there), so peers are also asked to test the reverse edge back to it,
+// completing the full N-by-N matrix; otherwise those edges are left out,
+// since self isn't listening for peers to dial.
+func MeasureMesh(ctx context.Context, self string, peers []string, cfg Config, selfServing bool) (*MeshResult, error) {
+	nodes := append([]string{self}, peers...)
+	result := &MeshResult{Nodes: nodes}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	for _, peer := range peers {
+		peer := peer
+		others := otherNodes(peers, peer)
+		if selfServing {
+			others = append(others, self)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			edges, err := requestMeshRun(ctx, peer, others, cfg)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				for _, to := range others {
+					result.Edges = append(result.Edges,
+						MeshEdge{From: peer, To: to, Direction: "download", Error: err.Error()},
+						MeshEdge{From: peer, To: to, Direction: "upload", Error: err.Error()})
+				}
+				return
+			}
+			result.Edges = append(result.Edges, edges...)
+		}()
+	}
+
+	selfEdges := measureEdgesFrom(ctx, self, peers, cfg)
+
+	wg.Wait()
+	mu.Lock()
+	result.Edges = append(result.Edges, selfEdges...)
+	mu.Unlock()
+
+	return result, nil
+}
+
+// measureEdgesFrom measures throughput from the local node to each of the
+// given peers, both directions concurrently (download and upload run at
+// the same time for a given peer, and every peer is handled concurrently
+// too), returning two MeshEdges per peer. Each of those concurrent
+// measurements gets its own PcapPath/SamplesOutput, derived from the peer
+// and direction, since a shared base path would otherwise have its file
+// truncated and clobbered by whichever measurement's os.Create ran last.
+func measureEdgesFrom(ctx context.Context, from string, peers []string, cfg Config) []MeshEdge {
+	edges := make([]MeshEdge, len(peers)*2)
+	var wg sync.WaitGroup
+
+	for i, peer := range peers {
+		i, peer := i, peer
+		peerCfg := cfg
+		peerCfg.BaseURL = "http://" + peer
+
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			dlCfg := peerCfg
+			dlCfg.PcapPath = taggedOutputPath(cfg.PcapPath, peer+".download")
+			dlCfg.SamplesOutput = taggedOutputPath(cfg.SamplesOutput, peer+".download")
+			edges[i*2] = measureEdge(ctx, from, peer, false, dlCfg)
+		}()
+		go func() {
+			defer wg.Done()
+			ulCfg := peerCfg
+			ulCfg.PcapPath = taggedOutputPath(cfg.PcapPath, peer+".upload")
+			ulCfg.SamplesOutput = taggedOutputPath(cfg.SamplesOutput, peer+".upload")
+			edges[i*2+1] = measureEdge(ctx, from, peer, true, ulCfg)
+		}()
+	}
+
+	wg.Wait()
+	return edges
+}
+
+// measureEdge runs one directional throughput measurement between from and
+// to, returning the resulting MeshEdge (Error set on failure).
+func measureEdge(ctx context.Context, from, to string, isUpload bool, cfg Config) MeshEdge {
+	direction := "download"
+	if isUpload {
+		direction = "upload"
+	}
+	tr, err := MeasureThroughput(ctx, isUpload, cfg)
+	if err != nil {
+		return MeshEdge{From: from, To: to, Direction: direction, Error: err.Error()}
+	}
+	return MeshEdge{From: from, To: to, Direction: direction, Bps: tr.Bps, LoadedLatencyMs: tr.LoadedLatencyMs}
+}
+
+// otherNodes returns nodes minus self, preserving order.
+func otherNodes(nodes []string, self string) []string {
+	others := make([]string, 0, len(nodes)-1)
+	for _, n := range nodes {
+		if n != self {
+			others = append(others, n)
+		}
+	}
+	return others
+}
+
+// requestMeshRun asks a peer (over its /__mesh/run control endpoint) to
+// measure throughput against the given set of peers and returns the
+// resulting edges.
+func requestMeshRun(ctx context.Context, peer string, peers []string, cfg Config) ([]MeshEdge, error) {
+	body, err := json.Marshal(meshRunRequest{Self: peer, Peers: peers})
+	if err != nil {
+		return nil, fmt.Errorf("encode mesh run request: %w", err)
+	}
+
+	url := "http://" + peer + "/" + meshRunPath
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("create mesh run request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := &http.Client{Timeout: cfg.Timeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("mesh run %s: %w", peer, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, resp.Body)
+		return nil, fmt.Errorf("mesh run %s: HTTP %d", peer, resp.StatusCode)
+	}
+
+	var out meshRunResponse
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, fmt.Errorf("decode mesh run response from %s: %w", peer, err)
+	}
+	return out.Edges, nil
+}
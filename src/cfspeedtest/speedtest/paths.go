@@ -0,0 +1,28 @@
+package speedtest
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// taggedOutputPath derives a per-target output path (e.g. "out.json" becomes
+// "out.some-server.json") so that concurrent targets sharing a single
+// --pcap/--samples base path don't clobber each other's file via concurrent
+// os.Create truncation. Mirrors the per-phase derivation main.go applies for
+// a single-target download/upload run. Returns "" unchanged when base is
+// unset.
+func taggedOutputPath(base, tag string) string {
+	if base == "" {
+		return ""
+	}
+	tag = sanitizeTag(tag)
+	ext := filepath.Ext(base)
+	return strings.TrimSuffix(base, ext) + "." + tag + ext
+}
+
+// sanitizeTag replaces characters that are awkward or unsafe in file names
+// (host:port separators, path separators) with "-".
+func sanitizeTag(tag string) string {
+	replacer := strings.NewReplacer(":", "-", "/", "-", "\\", "-")
+	return replacer.Replace(tag)
+}
@@ -0,0 +1,377 @@
+package speedtest
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/ip4defrag"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcap"
+	"github.com/google/gopacket/tcpassembly"
+)
+
+// pcapOutcome carries a completed capture's result (or error) from the
+// background capture goroutine back to MeasureThroughput.
+type pcapOutcome struct {
+	report *PcapReport
+	err    error
+}
+
+// stopPacketCapture signals a running capture to stop and waits for its
+// final report. stop/result are nil when cfg.PcapPath was unset, in which
+// case it's a no-op.
+func stopPacketCapture(stop chan struct{}, result chan pcapOutcome) *PcapReport {
+	if stop == nil {
+		return nil
+	}
+	close(stop)
+	outcome := <-result
+	if outcome.err != nil {
+		return nil
+	}
+	return outcome.report
+}
+
+// writePcapReport writes the full per-connection capture report to path as
+// indented JSON.
+func writePcapReport(path string, report *PcapReport) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("create pcap report %q: %w", path, err)
+	}
+	defer f.Close()
+
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	return enc.Encode(report)
+}
+
+// flushInterval bounds how long a reassembled-but-idle TCP stream is kept
+// around before tcpassembly considers it finished.
+const flushInterval = 30 * time.Second
+
+// ConnDiagnostics holds the packet-level diagnostics captured for a single
+// TCP connection during a --pcap run: how much of the slowness was caused
+// by loss/reordering versus a capped receive window, as opposed to the
+// link simply being slow.
+//
+// RwndMinBytes and RwndMaxBytes hold the raw 16-bit window field of the TCP
+// header, taken from segments in either direction of the connection; the
+// window-scale option is not applied, so on scaled connections they are
+// smaller than the real receive window in bytes.
+type ConnDiagnostics struct {
+	LocalPort    int       `json:"local_port"`
+	Retransmits  int       `json:"retransmits"`
+	OutOfOrder   int       `json:"out_of_order"`
+	RTTSamplesMs []float64 `json:"rtt_samples_ms,omitempty"`
+	RwndMinBytes int64     `json:"rwnd_min_bytes"`
+	RwndMaxBytes int64     `json:"rwnd_max_bytes"`
+}
+
+// PcapReport is the full capture-derived report for one throughput phase:
+// one ConnDiagnostics per TCP flow observed on the wire.
+type PcapReport struct {
+	Connections []ConnDiagnostics `json:"connections"`
+}
+
+// summary folds the per-connection reports into the aggregate fields
+// surfaced on ThroughputResult.
+func (r *PcapReport) summary() (retransmits, outOfOrder int, rwndMin int64, rttSamples []float64) {
+	rwndMin = -1
+	for _, c := range r.Connections {
+		retransmits += c.Retransmits
+		outOfOrder += c.OutOfOrder
+		rttSamples = append(rttSamples, c.RTTSamplesMs...)
+		if rwndMin < 0 || (c.RwndMinBytes > 0 && c.RwndMinBytes < rwndMin) {
+			rwndMin = c.RwndMinBytes
+		}
+	}
+	if rwndMin < 0 {
+		rwndMin = 0
+	}
+	return
+}
+
+// flowState tracks the bookkeeping needed to derive diagnostics from a raw
+// sequence of TCP segments on one direction-agnostic flow (keyed by the
+// unordered endpoint pair, so both directions of a connection share one
+// entry).
+type flowState struct {
+	diag            ConnDiagnostics
+	seenFwdSeq      map[uint32]bool
+	expectedFwdSeq  uint32
+	haveExpectedSeq bool
+
+	// firstSrcKey is the "ip:port" of whichever side sent the first segment
+	// seen for this flow; recordSegment compares each later segment's
+	// source against it to tell the two directions apart for timestamp/RTT
+	// correlation, since the flow key itself doesn't preserve direction.
+	firstSrcKey string
+
+	// sentTS[0] holds TSval -> observed-at for segments sent by the
+	// firstSrcKey side, sentTS[1] for the other side; when a segment
+	// arrives whose TSecr matches an entry in the opposite bucket, the gap
+	// between them is one RTT sample.
+	sentTS [2]map[uint32]time.Time
+}
+
+// tcpStreamFactory implements tcpassembly.StreamFactory, handing
+// reassembled byte streams to a no-op sink: the diagnostics we care about
+// (retransmits, reordering, window, RTT) are derived directly from the raw
+// TCP headers as they arrive, in recordSegment below, while tcpassembly
+// handles the IPv4 defrag + in-order reassembly bookkeeping requested for
+// this mode.
+type tcpStreamFactory struct{}
+
+func (f *tcpStreamFactory) New(_, _ gopacket.Flow) tcpassembly.Stream {
+	return &discardStream{}
+}
+
+// discardStream drains a reassembled stream without copying its bytes;
+// the capture's value here is the reassembly/defrag bookkeeping itself,
+// not the payload.
+type discardStream struct{}
+
+func (s *discardStream) Reassembled(reassembly []tcpassembly.Reassembly) {}
+func (s *discardStream) ReassemblyComplete()                             {}
+
+// pcapCollector accumulates per-flow diagnostics as raw packets arrive.
+type pcapCollector struct {
+	mu    sync.Mutex
+	flows map[string]*flowState
+}
+
+func newPcapCollector() *pcapCollector {
+	return &pcapCollector{flows: make(map[string]*flowState)}
+}
+
+// recordSegment updates the per-flow diagnostics for one TCP segment seen
+// on the wire: sequence-number bookkeeping for retransmits/reordering, the
+// advertised window, and an RTT sample derived from the TCP timestamp
+// option (RFC 7323) when present: this segment's TSval is recorded against
+// its arrival time, and if its TSecr matches a TSval previously recorded
+// for the *other* direction, the gap between the two arrival times is one
+// round trip.
+func (c *pcapCollector) recordSegment(flowKey, srcKey string, localPort int, tcp *layers.TCP, ts time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	st, ok := c.flows[flowKey]
+	if !ok {
+		st = &flowState{
+			seenFwdSeq:  make(map[uint32]bool),
+			diag:        ConnDiagnostics{LocalPort: localPort},
+			firstSrcKey: srcKey,
+			sentTS:      [2]map[uint32]time.Time{make(map[uint32]time.Time), make(map[uint32]time.Time)},
+		}
+		c.flows[flowKey] = st
+	}
+
+	if len(tcp.Payload) > 0 {
+		if st.seenFwdSeq[tcp.Seq] {
+			st.diag.Retransmits++
+		} else {
+			if st.haveExpectedSeq && tcp.Seq != st.expectedFwdSeq {
+				st.diag.OutOfOrder++
+			}
+			st.seenFwdSeq[tcp.Seq] = true
+			st.expectedFwdSeq = tcp.Seq + uint32(len(tcp.Payload))
+			st.haveExpectedSeq = true
+		}
+	}
+
+	window := int64(tcp.Window)
+	if st.diag.RwndMinBytes == 0 || window < st.diag.RwndMinBytes {
+		st.diag.RwndMinBytes = window
+	}
+	if window > st.diag.RwndMaxBytes {
+		st.diag.RwndMaxBytes = window
+	}
+
+	dir := 0
+	if srcKey != st.firstSrcKey {
+		dir = 1
+	}
+	other := 1 - dir
+
+	if tsval, tsecr, ok := parseTCPTimestamps(tcp); ok {
+		st.sentTS[dir][tsval] = ts
+		if sentAt, ok := st.sentTS[other][tsecr]; ok {
+			if rtt := ts.Sub(sentAt); rtt > 0 && rtt < time.Second {
+				st.diag.RTTSamplesMs = append(st.diag.RTTSamplesMs, rtt.Seconds()*1000)
+			}
+			delete(st.sentTS[other], tsecr)
+		}
+	}
+}
+
+// parseTCPTimestamps extracts the TSval/TSecr pair from the TCP Timestamps
+// option (RFC 7323, kind 8), if present.
+func parseTCPTimestamps(tcp *layers.TCP) (tsval, tsecr uint32, ok bool) {
+	for _, opt := range tcp.Options {
+		if opt.OptionType == layers.TCPOptionKindTimestamps && len(opt.OptionData) == 8 {
+			return binary.BigEndian.Uint32(opt.OptionData[0:4]), binary.BigEndian.Uint32(opt.OptionData[4:8]), true
+		}
+	}
+	return 0, 0, false
+}
+
+func (c *pcapCollector) report() *PcapReport {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	report := &PcapReport{}
+	for _, st := range c.flows {
+		report.Connections = append(report.Connections, st.diag)
+	}
+	sort.Slice(report.Connections, func(i, j int) bool {
+		return report.Connections[i].LocalPort < report.Connections[j].LocalPort
+	})
+	return report
+}
+
+// runPacketCapture opens a live capture on cfg.Interface (or pcap's default
+// device when unset), filtered by BPF to the throughput target host, and
+// feeds packets through IPv4 defrag + TCP reassembly while recording
+// per-flow diagnostics. It runs until stop is closed or ctx is done, then
+// flushes any in-progress reassembly and returns the collected report.
+func runPacketCapture(ctx context.Context, cfg Config, stop <-chan struct{}) (*PcapReport, error) {
+	device := cfg.Interface
+	if device == "" {
+		devices, err := pcap.FindAllDevs()
+		if err != nil || len(devices) == 0 {
+			return nil, fmt.Errorf("find capture device: %w", err)
+		}
+		device = devices[0].Name
+	}
+
+	handle, err := pcap.OpenLive(device, 1600, true, pcap.BlockForever)
+	if err != nil {
+		return nil, fmt.Errorf("open pcap on %q: %w", device, err)
+	}
+	defer handle.Close()
+
+	host, err := captureHost(cfg.targetBaseURL())
+	if err != nil {
+		return nil, err
+	}
+	if err := handle.SetBPFFilter(fmt.Sprintf("tcp and host %s", host)); err != nil {
+		return nil, fmt.Errorf("set BPF filter: %w", err)
+	}
+
+	remoteIPs, err := net.LookupIP(host)
+	if err != nil {
+		return nil, fmt.Errorf("resolve capture host %q: %w", host, err)
+	}
+	remote := make(map[string]bool, len(remoteIPs))
+	for _, ip := range remoteIPs {
+		remote[ip.String()] = true
+	}
+
+	defragger := ip4defrag.NewIPv4Defragmenter()
+	streamPool := tcpassembly.NewStreamPool(&tcpStreamFactory{})
+	assembler := tcpassembly.NewAssembler(streamPool)
+	collector := newPcapCollector()
+
+	packets := gopacket.NewPacketSource(handle, handle.LinkType()).Packets()
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			assembler.FlushAll()
+			return collector.report(), nil
+		case <-ctx.Done():
+			assembler.FlushAll()
+			return collector.report(), nil
+		case <-ticker.C:
+			assembler.FlushOlderThan(time.Now().Add(-flushInterval))
+		case packet, ok := <-packets:
+			if !ok {
+				assembler.FlushAll()
+				return collector.report(), nil
+			}
+
+			var tcp *layers.TCP
+			var netFlow gopacket.Flow
+
+			if ipLayer := packet.Layer(layers.LayerTypeIPv4); ipLayer != nil {
+				ip4 := ipLayer.(*layers.IPv4)
+				fragmented := ip4.FragOffset != 0 || ip4.Flags&layers.IPv4MoreFragments != 0
+				newIP4, err := defragger.DefragIPv4(ip4)
+				if err != nil {
+					continue // failed security checks on a malformed fragment
+				}
+				if newIP4 == nil {
+					continue // awaiting the rest of the datagram
+				}
+				if fragmented {
+					// newIP4.Payload now holds the full reassembled
+					// datagram; the TCP header must be re-decoded from it,
+					// since the original packet only ever carried one
+					// fragment's worth of bytes.
+					reassembled := &layers.TCP{}
+					if err := reassembled.DecodeFromBytes(newIP4.Payload, gopacket.NilDecodeFeedback); err != nil {
+						continue // not a valid TCP segment once reassembled
+					}
+					tcp = reassembled
+					netFlow = newIP4.NetworkFlow()
+				}
+			}
+
+			if tcp == nil {
+				tcpLayer := packet.Layer(layers.LayerTypeTCP)
+				if tcpLayer == nil || packet.NetworkLayer() == nil {
+					continue
+				}
+				tcp = tcpLayer.(*layers.TCP)
+				netFlow = packet.NetworkLayer().NetworkFlow()
+			}
+
+			assembler.AssembleWithTimestamp(netFlow, tcp, packet.Metadata().Timestamp)
+
+			srcIP, dstIP := netFlow.Src().String(), netFlow.Dst().String()
+			srcKey := fmt.Sprintf("%s:%d", srcIP, tcp.SrcPort)
+			dstKey := fmt.Sprintf("%s:%d", dstIP, tcp.DstPort)
+
+			// localPort is whichever side isn't the capture target, so it's
+			// correct regardless of which direction this segment travels.
+			localPort := int(tcp.SrcPort)
+			if remote[srcIP] {
+				localPort = int(tcp.DstPort)
+			}
+
+			// Normalize the flow key so both directions of a connection
+			// land in the same flowState entry: sort the endpoint pair
+			// rather than using gopacket's direction-dependent Flow.String().
+			flowKey := srcKey + "<->" + dstKey
+			if dstKey < srcKey {
+				flowKey = dstKey + "<->" + srcKey
+			}
+			collector.recordSegment(flowKey, srcKey, localPort, tcp, packet.Metadata().Timestamp)
+		}
+	}
+}
+
+// captureHost extracts the bare hostname from a base URL for use in a BPF
+// "host" filter.
+func captureHost(baseURL string) (string, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return "", fmt.Errorf("parse target URL: %w", err)
+	}
+	if u.Hostname() == "" {
+		return "", fmt.Errorf("target URL %q has no host", baseURL)
+	}
+	return u.Hostname(), nil
+}
@@ -0,0 +1,72 @@
+package speedtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// sampleRecord is one line of NDJSON written to cfg.SamplesOutput. Two kinds
+// of record share the type, distinguished by which fields are populated:
+// aggregate ticks (one per 200ms sampling interval, mirroring ProgressSample)
+// and per-request records (one per completed HTTP request). Sharing a type
+// keeps the writer itself kind-agnostic; a consumer tells them apart by the
+// presence of Status, which only per-request records set.
+type sampleRecord struct {
+	TMs   int64  `json:"t_ms"`
+	Phase string `json:"phase"`
+
+	// Populated on aggregate tick records.
+	IntervalBytes   int64   `json:"interval_bytes,omitempty"`
+	Mbps            float64 `json:"mbps,omitempty"`
+	ActiveWorkers   int     `json:"active_workers,omitempty"`
+	LoadedLatencyMs float64 `json:"loaded_latency_ms,omitempty"`
+
+	// Populated on per-request records.
+	Worker     int     `json:"worker,omitempty"`
+	Bytes      int64   `json:"bytes,omitempty"`
+	DurationMs float64 `json:"duration_ms,omitempty"`
+	ServerMs   float64 `json:"server_ms,omitempty"`
+	Status     int     `json:"status,omitempty"`
+	ChunkSize  int     `json:"chunk_size,omitempty"`
+}
+
+// samplesWriter appends NDJSON sample/request records to cfg.SamplesOutput,
+// one JSON object per line. Safe for concurrent use: the aggregate-tick
+// sampling loop and every worker's per-request record share one writer.
+type samplesWriter struct {
+	mu  sync.Mutex
+	f   *os.File
+	enc *json.Encoder
+}
+
+// newSamplesWriter creates (or truncates) path and returns a writer ready to
+// append NDJSON records to it.
+func newSamplesWriter(path string) (*samplesWriter, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("create samples output %q: %w", path, err)
+	}
+	return &samplesWriter{f: f, enc: json.NewEncoder(f)}, nil
+}
+
+// write appends rec as one NDJSON line. Encode errors are swallowed: a
+// malformed record shouldn't abort the throughput test that's producing it.
+func (w *samplesWriter) write(rec sampleRecord) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	_ = w.enc.Encode(rec)
+}
+
+// Close flushes the underlying file to disk.
+func (w *samplesWriter) Close() error {
+	return w.f.Close()
+}
+
+// elapsedMs returns the milliseconds elapsed since start, for stamping
+// sample records against the start of the throughput phase.
+func elapsedMs(start, now time.Time) int64 {
+	return now.Sub(start).Milliseconds()
+}
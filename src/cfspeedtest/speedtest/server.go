@@ -0,0 +1,83 @@
+package speedtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// zeroChunk is reused across download responses; its contents don't matter,
+// only its size, so it is allocated once and shared read-only.
+var zeroChunk = make([]byte, readBufferSize)
+
+// ServeMesh starts an HTTP server exposing the /__down and /__up handlers
+// used by the throughput workers (compatible with speed.cloudflare.com's own
+// endpoints), plus a /__mesh/run control endpoint that lets a coordinator
+// ask this node (see MeasureMesh) to measure throughput against a set of
+// peers and report the resulting edges back as JSON.
+func ServeMesh(addr string, cfg Config) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/__down", handleDown)
+	mux.HandleFunc("/__up", handleUp)
+	mux.HandleFunc("/"+meshRunPath, handleMeshRun(cfg))
+
+	return http.ListenAndServe(addr, mux)
+}
+
+// handleDown mimics speed.cloudflare.com's /__down?bytes= endpoint: it
+// streams back the requested number of bytes and reports its own
+// processing time via Server-Timing so loaded-latency probes against mesh
+// peers work the same way they do against the Cloudflare edge.
+func handleDown(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+
+	n, err := strconv.Atoi(r.URL.Query().Get("bytes"))
+	if err != nil || n < 0 {
+		http.Error(w, "invalid bytes parameter", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Server-Timing", fmt.Sprintf("cfRequestDuration;dur=%.2f", time.Since(start).Seconds()*1000))
+	w.WriteHeader(http.StatusOK)
+
+	for remaining := n; remaining > 0; {
+		chunk := zeroChunk
+		if remaining < len(chunk) {
+			chunk = chunk[:remaining]
+		}
+		written, err := w.Write(chunk)
+		remaining -= written
+		if err != nil {
+			return
+		}
+	}
+}
+
+// handleUp mimics speed.cloudflare.com's /__up endpoint: it discards the
+// request body and acknowledges receipt.
+func handleUp(w http.ResponseWriter, r *http.Request) {
+	io.Copy(io.Discard, r.Body)
+	w.WriteHeader(http.StatusOK)
+}
+
+// handleMeshRun services the control-plane /__mesh/run endpoint: it
+// measures throughput from this node to every peer named in the request
+// body and returns the resulting edges.
+func handleMeshRun(cfg Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req meshRunRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid mesh run request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		edges := measureEdgesFrom(r.Context(), req.Self, req.Peers, cfg)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(meshRunResponse{Edges: edges})
+	}
+}
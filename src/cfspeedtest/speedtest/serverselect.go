@@ -0,0 +1,207 @@
+package speedtest
+
+import (
+	"context"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ServerCandidate is a single throughput test endpoint considered during
+// server selection: the built-in Cloudflare edge, or a user-supplied
+// --custom-url target (e.g. a mesh peer or another provider's speedtest
+// endpoint implementing the same /__down and /__up contract).
+type ServerCandidate struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+}
+
+// ServerScore holds the probe results and resulting weighted score for one
+// candidate server, as produced by SelectServer. Lower Score is better.
+type ServerScore struct {
+	Candidate ServerCandidate `json:"candidate"`
+	RTTMs     float64         `json:"rtt_ms"`
+	JitterMs  float64         `json:"jitter_ms"`
+	Score     float64         `json:"score"`
+	Error     string          `json:"error,omitempty"`
+}
+
+// ServerResult pairs a selected candidate with the throughput measured
+// against it, for --multi runs that test more than one server.
+type ServerResult struct {
+	Candidate ServerCandidate   `json:"candidate"`
+	Download  *ThroughputResult `json:"download,omitempty"`
+	Upload    *ThroughputResult `json:"upload,omitempty"`
+	Error     string            `json:"error,omitempty"`
+}
+
+// Weights for combining RTT and jitter into a single ranking score; both
+// terms are minimized.
+const (
+	rttWeight    = 1.0
+	jitterWeight = 2.0 // jitter is a stronger congestion/bufferbloat signal than raw RTT
+
+	probeSamples = 5 // latency burst size used to rank each candidate
+)
+
+// DefaultCandidates returns the built-in candidate list: the Cloudflare
+// edge plus any user-supplied --custom-url targets.
+//
+// Cloudflare's speed test endpoint (speed.cloudflare.com) is anycast to a
+// single IP and always routes to whichever colo is nearest the client at
+// the network layer; there's no public per-colo hostname to address a
+// specific PoP directly, so there's exactly one built-in candidate. Ranking
+// and --multi only become meaningful once --custom-url adds other real,
+// independently-addressable endpoints (other mesh nodes, other providers'
+// speedtest servers) to compare against it.
+func DefaultCandidates(customURLs string) []ServerCandidate {
+	candidates := []ServerCandidate{{Name: "cloudflare", URL: baseURL}}
+	return append(candidates, ParseCustomURLs(customURLs)...)
+}
+
+// ParseCustomURLs splits a comma-separated --custom-url flag value into
+// named candidates (name and URL are the same raw value).
+func ParseCustomURLs(raw string) []ServerCandidate {
+	if raw == "" {
+		return nil
+	}
+	var out []ServerCandidate
+	for _, u := range strings.Split(raw, ",") {
+		u = strings.TrimSpace(u)
+		if u == "" {
+			continue
+		}
+		out = append(out, ServerCandidate{Name: u, URL: u})
+	}
+	return out
+}
+
+// SelectServer probes every candidate with a small latency burst, scores
+// each by weighted RTT + jitter, and returns the scores sorted best-first.
+func SelectServer(ctx context.Context, candidates []ServerCandidate, cfg Config) []ServerScore {
+	scores := make([]ServerScore, len(candidates))
+	var wg sync.WaitGroup
+	for i, c := range candidates {
+		i, c := i, c
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			scores[i] = scoreCandidate(ctx, c, cfg)
+		}()
+	}
+	wg.Wait()
+
+	sort.Slice(scores, func(i, j int) bool {
+		iErr, jErr := scores[i].Error != "", scores[j].Error != ""
+		if iErr != jErr {
+			return jErr // errored candidates sort last
+		}
+		return scores[i].Score < scores[j].Score
+	})
+	return scores
+}
+
+// scoreCandidate runs a small latency burst against one candidate and
+// computes its weighted score.
+func scoreCandidate(ctx context.Context, c ServerCandidate, cfg Config) ServerScore {
+	client, err := newWorkerClient(10*time.Second, cfg.Interface)
+	if err != nil {
+		return ServerScore{Candidate: c, Error: err.Error()}
+	}
+	defer client.CloseIdleConnections()
+
+	url := c.URL + "/" + downloadPath + "0"
+	var samples []float64
+	for i := 0; i < probeSamples; i++ {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return ServerScore{Candidate: c, Error: err.Error()}
+		}
+		req.Header.Set("User-Agent", "cfspeedtest/1.0")
+
+		start := time.Now()
+		resp, err := client.Do(req)
+		if err != nil {
+			return ServerScore{Candidate: c, Error: err.Error()}
+		}
+		elapsed := time.Since(start).Seconds() * 1000
+		serverMs := parseServerTiming(resp)
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+
+		latency := elapsed - serverMs
+		if latency < 0 {
+			latency = 0
+		}
+		samples = append(samples, latency)
+	}
+
+	rtt, jitter := computeLatencyStats(samples)
+
+	return ServerScore{
+		Candidate: c,
+		RTTMs:     rtt,
+		JitterMs:  jitter,
+		Score:     math.Round((rtt*rttWeight+jitter*jitterWeight)*10) / 10,
+	}
+}
+
+// MeasureMultiServer runs the download and upload throughput phases against
+// each of the given servers concurrently, returning one ServerResult per
+// server. Used by --multi to benchmark more than one PoP and detect
+// asymmetric routing between them.
+func MeasureMultiServer(ctx context.Context, servers []ServerCandidate, cfg Config) []ServerResult {
+	results := make([]ServerResult, len(servers))
+	var wg sync.WaitGroup
+	for i, s := range servers {
+		i, s := i, s
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = measureServer(ctx, s, cfg)
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+// measureServer runs the requested throughput phases against s. Each phase
+// gets its own PcapPath/SamplesOutput derived from both the server name and
+// the phase, since MeasureMultiServer runs every server concurrently and a
+// shared base path would otherwise have its file truncated and clobbered by
+// more than one phase/server writing to it at once.
+func measureServer(ctx context.Context, s ServerCandidate, cfg Config) ServerResult {
+	result := ServerResult{Candidate: s}
+
+	if !cfg.UploadOnly {
+		dlCfg := cfg
+		dlCfg.BaseURL = s.URL
+		dlCfg.PcapPath = taggedOutputPath(cfg.PcapPath, s.Name+".download")
+		dlCfg.SamplesOutput = taggedOutputPath(cfg.SamplesOutput, s.Name+".download")
+		dl, err := MeasureThroughput(ctx, false, dlCfg)
+		if err != nil {
+			result.Error = err.Error()
+			return result
+		}
+		result.Download = dl
+	}
+
+	if !cfg.DownloadOnly {
+		ulCfg := cfg
+		ulCfg.BaseURL = s.URL
+		ulCfg.PcapPath = taggedOutputPath(cfg.PcapPath, s.Name+".upload")
+		ulCfg.SamplesOutput = taggedOutputPath(cfg.SamplesOutput, s.Name+".upload")
+		ul, err := MeasureThroughput(ctx, true, ulCfg)
+		if err != nil {
+			result.Error = err.Error()
+			return result
+		}
+		result.Upload = ul
+	}
+
+	return result
+}
@@ -17,8 +17,23 @@ const (
 	probeInterval        = 500 * time.Millisecond
 	warmupFraction       = 0.20 // Skip first 20% of samples
 	readBufferSize       = 81920 // 80 KB read buffer per worker
+
+	rampStepInterval    = 1 * time.Second // how long each adaptive-streams ramp step runs
+	rampGrowthThreshold = 0.10            // ramp stops once the next doubling improves throughput by less than this
+	rampLatencyFactor   = 2.0             // ramp stops once loaded latency exceeds this multiple of the unloaded baseline
+	maxAdaptiveStreams  = 128             // upper bound on ramp doubling, well above any realistic saturation point
+	workerIdlePoll      = 50 * time.Millisecond
 )
 
+// targetBaseURL returns cfg.BaseURL when set (e.g. a mesh peer), otherwise
+// the Cloudflare edge used by the default internet-facing test.
+func (cfg Config) targetBaseURL() string {
+	if cfg.BaseURL != "" {
+		return cfg.BaseURL
+	}
+	return baseURL
+}
+
 // newWorkerClient creates an HTTP client that forces HTTP/1.1 and optionally
 // binds to a specific interface, ensuring each worker gets its own TCP connection.
 func newWorkerClient(timeout time.Duration, ifaceName string) (*http.Client, error) {
@@ -48,16 +63,92 @@ func (cr *countingReader) Read(p []byte) (int, error) {
 	return n, err
 }
 
+// RampPoint is one sample taken during an adaptive-streams ramp: the
+// concurrency level in effect and the throughput/latency observed at that
+// level, used to tell a bandwidth-limited path from a bufferbloat-limited one.
+type RampPoint struct {
+	Streams         int     `json:"streams"`
+	Mbps            float64 `json:"mbps"`
+	LoadedLatencyMs float64 `json:"loaded_latency_ms"`
+}
+
+// workerPool is a dynamically resizable group of throughput workers. Workers
+// are identified by a monotonically assigned index; a worker whose index is
+// at or above the current desired size parks instead of making requests.
+// This lets MeasureThroughput grow (and, once, shrink back by one ramp step)
+// the active concurrency mid-test without tearing down and recreating
+// goroutines or connections.
+type workerPool struct {
+	desired atomic.Int32
+	mu      sync.Mutex
+	spawned int
+	wg      sync.WaitGroup
+	spawn   func(id int)
+}
+
+// resize grows the pool to n workers, spawning any that don't exist yet, and
+// updates the desired size so existing workers above n park on their next
+// idle check. It never kills a goroutine outright; parked workers simply
+// stop issuing requests.
+func (p *workerPool) resize(n int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.desired.Store(int32(n))
+	for p.spawned < n {
+		id := p.spawned
+		p.spawned++
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			p.spawn(id)
+		}()
+	}
+}
+
+// active reports whether worker id is currently allowed to issue requests.
+func (p *workerPool) active(id int) bool {
+	return int32(id) < p.desired.Load()
+}
+
 // MeasureThroughput runs concurrent download or upload workers for the given
 // duration, sampling aggregate throughput every 200ms. A concurrent latency
 // probe measures loaded latency every 500ms.
+//
+// When cfg.AdaptiveStreams is set, the worker pool starts at 1 stream and
+// doubles every ~1s until throughput stops improving or loaded latency
+// climbs past rampLatencyFactor times cfg.UnloadedLatencyMs, then backs off one step before
+// entering the steady-state measurement window below.
 func MeasureThroughput(ctx context.Context, isUpload bool, cfg Config) (*ThroughputResult, error) {
-	ctx, cancel := context.WithTimeout(ctx, cfg.Duration+5*time.Second)
+	timeout := cfg.Duration + 5*time.Second
+	if cfg.AdaptiveStreams {
+		// The ramp runs before the steady-state window below and eats into
+		// this same deadline, so budget for its worst case (every doubling
+		// step up to maxAdaptiveStreams, never saturating) on top of the
+		// steady-state duration, or a slow-to-saturate path would hit
+		// ctx.Done() mid steady-state and fail the whole phase.
+		timeout += rampMaxDuration()
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 
 	var totalBytes atomic.Int64
 	var activeWorkers atomic.Int32
-	var wg sync.WaitGroup
+
+	phase := "download"
+	if isUpload {
+		phase = "upload"
+	}
+	phaseStart := time.Now()
+
+	var samplesW *samplesWriter
+	if cfg.SamplesOutput != "" {
+		w, err := newSamplesWriter(cfg.SamplesOutput)
+		if err != nil {
+			return nil, err
+		}
+		samplesW = w
+		defer samplesW.Close()
+	}
 
 	// Loaded latency probe samples
 	var latencyMu sync.Mutex
@@ -77,124 +168,186 @@ func MeasureThroughput(ctx context.Context, isUpload bool, cfg Config) (*Through
 	// Signal to stop workers when duration expires
 	stopCh := make(chan struct{})
 
-	// Launch throughput workers
-	for w := 0; w < cfg.Streams; w++ {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			client, err := newWorkerClient(60*time.Second, cfg.Interface)
-			if err != nil {
+	pool := &workerPool{}
+	pool.spawn = func(id int) {
+		client, err := newWorkerClient(60*time.Second, cfg.Interface)
+		if err != nil {
+			return
+		}
+		activeWorkers.Add(1)
+		defer client.CloseIdleConnections()
+
+		workerChunk := chunkSize
+		buf := make([]byte, readBufferSize) // per-worker read buffer
+
+		for {
+			select {
+			case <-stopCh:
 				return
+			case <-ctx.Done():
+				return
+			default:
 			}
-			activeWorkers.Add(1)
-			defer client.CloseIdleConnections()
 
-			workerChunk := chunkSize
-			buf := make([]byte, readBufferSize) // per-worker read buffer
+			if !pool.active(id) {
+				time.Sleep(workerIdlePoll)
+				continue
+			}
 
-			for {
-				select {
-				case <-stopCh:
-					return
-				case <-ctx.Done():
-					return
-				default:
+			if isUpload {
+				requestStart := time.Now()
+				url := cfg.targetBaseURL() + "/" + uploadPath
+				cr := &countingReader{
+					r:       bytes.NewReader(uploadPayload),
+					counter: &totalBytes,
 				}
-
-				if isUpload {
-					url := baseURL + "/" + uploadPath
-					cr := &countingReader{
-						r:       bytes.NewReader(uploadPayload),
-						counter: &totalBytes,
-					}
-					req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, cr)
-					if err != nil {
+				req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, cr)
+				if err != nil {
+					continue
+				}
+				req.Header.Set("User-Agent", "cfspeedtest/1.0")
+				req.ContentLength = int64(len(uploadPayload))
+
+				resp, err := client.Do(req)
+				if err != nil {
+					select {
+					case <-stopCh:
+						return
+					case <-ctx.Done():
+						return
+					default:
+						time.Sleep(100 * time.Millisecond)
 						continue
 					}
-					req.Header.Set("User-Agent", "cfspeedtest/1.0")
-					req.ContentLength = int64(len(uploadPayload))
-
-					resp, err := client.Do(req)
-					if err != nil {
-						select {
-						case <-stopCh:
-							return
-						case <-ctx.Done():
-							return
-						default:
-							time.Sleep(100 * time.Millisecond)
-							continue
-						}
-					}
-					resp.Body.Close()
+				}
+				resp.Body.Close()
+
+				if samplesW != nil {
+					samplesW.write(sampleRecord{
+						TMs:        elapsedMs(phaseStart, time.Now()),
+						Phase:      phase,
+						Worker:     id,
+						Bytes:      int64(len(uploadPayload)),
+						DurationMs: float64(time.Since(requestStart).Microseconds()) / 1000.0,
+						ServerMs:   parseServerTiming(resp),
+						Status:     resp.StatusCode,
+						ChunkSize:  chunkSize,
+					})
+				}
 
-					if resp.StatusCode != http.StatusOK {
+				if resp.StatusCode != http.StatusOK {
+					time.Sleep(100 * time.Millisecond)
+				}
+			} else {
+				requestStart := time.Now()
+				requestChunk := workerChunk
+				url := fmt.Sprintf("%s/%s%d", cfg.targetBaseURL(), downloadPath, requestChunk)
+				req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+				if err != nil {
+					continue
+				}
+				req.Header.Set("User-Agent", "cfspeedtest/1.0")
+
+				resp, err := client.Do(req)
+				if err != nil {
+					select {
+					case <-stopCh:
+						return
+					case <-ctx.Done():
+						return
+					default:
 						time.Sleep(100 * time.Millisecond)
-					}
-				} else {
-					url := fmt.Sprintf("%s/%s%d", baseURL, downloadPath, workerChunk)
-					req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
-					if err != nil {
 						continue
 					}
-					req.Header.Set("User-Agent", "cfspeedtest/1.0")
+				}
 
-					resp, err := client.Do(req)
-					if err != nil {
-						select {
-						case <-stopCh:
-							return
-						case <-ctx.Done():
-							return
-						default:
-							time.Sleep(100 * time.Millisecond)
-							continue
-						}
+				if resp.StatusCode != http.StatusOK {
+					resp.Body.Close()
+					if samplesW != nil {
+						samplesW.write(sampleRecord{
+							TMs:        elapsedMs(phaseStart, time.Now()),
+							Phase:      phase,
+							Worker:     id,
+							DurationMs: float64(time.Since(requestStart).Microseconds()) / 1000.0,
+							ServerMs:   parseServerTiming(resp),
+							Status:     resp.StatusCode,
+							ChunkSize:  requestChunk,
+						})
 					}
-
-					if resp.StatusCode != http.StatusOK {
-						resp.Body.Close()
-						// On 429: halve chunk size (matching cloudflare-speed-cli behavior)
-						if resp.StatusCode == 429 {
-							next := workerChunk / 2
-							if next < minDownloadChunkSize {
-								next = minDownloadChunkSize
-							}
-							if next < workerChunk {
-								workerChunk = next
-							}
+					// On 429: halve chunk size (matching cloudflare-speed-cli behavior)
+					if resp.StatusCode == 429 {
+						next := workerChunk / 2
+						if next < minDownloadChunkSize {
+							next = minDownloadChunkSize
+						}
+						if next < workerChunk {
+							workerChunk = next
 						}
-						time.Sleep(100 * time.Millisecond)
-						continue
 					}
+					time.Sleep(100 * time.Millisecond)
+					continue
+				}
 
-					// Stream download, counting bytes incrementally
-					for {
-						n, err := resp.Body.Read(buf)
-						if n > 0 {
-							totalBytes.Add(int64(n))
-						}
-						if err != nil {
-							break
-						}
+				serverMs := parseServerTiming(resp)
+
+				// Stream download, counting bytes incrementally
+				var reqBytes int64
+				for {
+					n, err := resp.Body.Read(buf)
+					if n > 0 {
+						totalBytes.Add(int64(n))
+						reqBytes += int64(n)
 					}
-					resp.Body.Close()
+					if err != nil {
+						break
+					}
+				}
+				resp.Body.Close()
+
+				if samplesW != nil {
+					samplesW.write(sampleRecord{
+						TMs:        elapsedMs(phaseStart, time.Now()),
+						Phase:      phase,
+						Worker:     id,
+						Bytes:      reqBytes,
+						DurationMs: float64(time.Since(requestStart).Microseconds()) / 1000.0,
+						ServerMs:   serverMs,
+						Status:     resp.StatusCode,
+						ChunkSize:  requestChunk,
+					})
 				}
 			}
+		}
+	}
+
+	var pcapStop chan struct{}
+	var pcapResult chan pcapOutcome
+	if cfg.PcapPath != "" {
+		pcapStop = make(chan struct{})
+		pcapResult = make(chan pcapOutcome, 1)
+		go func() {
+			report, err := runPacketCapture(ctx, cfg, pcapStop)
+			pcapResult <- pcapOutcome{report: report, err: err}
 		}()
 	}
 
+	initialStreams := cfg.Streams
+	if cfg.AdaptiveStreams {
+		initialStreams = 1
+	}
+	pool.resize(initialStreams)
+
 	// Launch latency probe
-	wg.Add(1)
+	pool.wg.Add(1)
 	go func() {
-		defer wg.Done()
+		defer pool.wg.Done()
 		probeClient, err := newWorkerClient(10*time.Second, cfg.Interface)
 		if err != nil {
 			return
 		}
 		defer probeClient.CloseIdleConnections()
 
-		probeURL := baseURL + "/" + downloadPath + "0"
+		probeURL := cfg.targetBaseURL() + "/" + downloadPath + "0"
 		for {
 			select {
 			case <-stopCh:
@@ -248,15 +401,36 @@ func MeasureThroughput(ctx context.Context, isUpload bool, cfg Config) (*Through
 
 	// Brief wait for workers to initialize, then check if any bound successfully
 	time.Sleep(100 * time.Millisecond)
-	if activeWorkers.Load() == 0 && cfg.Streams > 0 {
+	if activeWorkers.Load() == 0 && initialStreams > 0 {
 		close(stopCh)
-		wg.Wait()
+		pool.wg.Wait()
+		stopPacketCapture(pcapStop, pcapResult)
 		return nil, fmt.Errorf("no workers could bind to interface %q", cfg.Interface)
 	}
 
+	var rampCurve []RampPoint
+	var kneeStreams int
+	chosenStreams := cfg.Streams
+
+	// rampLatencyCount marks how many loadedLatencies samples had already
+	// accumulated once the ramp concluded, so neither the tick emission
+	// below nor the final aggregate stats mix ramp-phase latency (measured
+	// at concurrency levels below the chosen steady-state one) into the
+	// steady-state window, the same way Bps is already restricted to
+	// post-ramp mbpsSamples.
+	var rampLatencyCount int
+
+	if cfg.AdaptiveStreams {
+		chosenStreams, rampCurve, kneeStreams = rampAdaptiveStreams(ctx, cfg, pool, &totalBytes, &latencyMu, &loadedLatencies)
+		latencyMu.Lock()
+		rampLatencyCount = len(loadedLatencies)
+		latencyMu.Unlock()
+	}
+
 	// Sample throughput at regular intervals
 	var mbpsSamples []float64
 	var lastBytes int64
+	latencySeen := rampLatencyCount
 	start := time.Now()
 	lastTime := start
 
@@ -264,7 +438,8 @@ func MeasureThroughput(ctx context.Context, isUpload bool, cfg Config) (*Through
 		select {
 		case <-ctx.Done():
 			close(stopCh)
-			wg.Wait()
+			pool.wg.Wait()
+			stopPacketCapture(pcapStop, pcapResult)
 			return nil, ctx.Err()
 		case <-time.After(sampleInterval):
 		}
@@ -274,18 +449,65 @@ func MeasureThroughput(ctx context.Context, isUpload bool, cfg Config) (*Through
 		intervalBytes := currentBytes - lastBytes
 		intervalSecs := now.Sub(lastTime).Seconds()
 
+		var mbps float64
 		if intervalSecs > 0.01 {
-			mbps := (float64(intervalBytes) * 8.0 / 1_000_000.0) / intervalSecs
+			mbps = (float64(intervalBytes) * 8.0 / 1_000_000.0) / intervalSecs
 			mbpsSamples = append(mbpsSamples, mbps)
 		}
 
+		if cfg.ProgressSink != nil || samplesW != nil {
+			latencyMu.Lock()
+			window := loadedLatencies[latencySeen:]
+			latencySeen = len(loadedLatencies)
+			var intervalLatency float64
+			if len(window) > 0 {
+				intervalLatency, _ = computeLatencyStats(window)
+			}
+			latencyMu.Unlock()
+
+			// Workers parked above the pool's desired size issue no requests,
+			// so they are not counted as active.
+			workers := int(activeWorkers.Load())
+			if desired := int(pool.desired.Load()); desired < workers {
+				workers = desired
+			}
+
+			if cfg.ProgressSink != nil {
+				cfg.ProgressSink(ProgressSample{
+					Phase:           phase,
+					ElapsedMs:       now.Sub(start).Milliseconds(),
+					IntervalBytes:   intervalBytes,
+					TotalBytes:      currentBytes,
+					Mbps:            mbps,
+					LoadedLatencyMs: intervalLatency,
+					ActiveWorkers:   workers,
+				})
+			}
+
+			if samplesW != nil {
+				samplesW.write(sampleRecord{
+					TMs:             elapsedMs(phaseStart, now),
+					Phase:           phase,
+					IntervalBytes:   intervalBytes,
+					Mbps:            mbps,
+					ActiveWorkers:   workers,
+					LoadedLatencyMs: intervalLatency,
+				})
+			}
+		}
+
 		lastBytes = currentBytes
 		lastTime = now
 	}
 
 	// Stop workers
 	close(stopCh)
-	wg.Wait()
+	pool.wg.Wait()
+
+	pcapReport := stopPacketCapture(pcapStop, pcapResult)
+	if cfg.PcapPath != "" && pcapReport != nil {
+		writePcapReport(cfg.PcapPath, pcapReport)
+	}
 
 	finalBytes := totalBytes.Load()
 	if len(mbpsSamples) == 0 {
@@ -306,17 +528,112 @@ func MeasureThroughput(ctx context.Context, isUpload bool, cfg Config) (*Through
 	meanMbps := sum / float64(len(steadySamples))
 	bps := meanMbps * 1_000_000.0
 
-	// Compute loaded latency stats
+	// Compute loaded latency stats over the steady-state window only,
+	// skipping whatever accumulated during the adaptive-streams ramp.
 	latencyMu.Lock()
-	samples := loadedLatencies
+	samples := loadedLatencies[rampLatencyCount:]
 	latencyMu.Unlock()
 
 	loadedMedian, loadedJitter := computeLatencyStats(samples)
 
-	return &ThroughputResult{
+	result := &ThroughputResult{
 		Bps:             bps,
 		Bytes:           finalBytes,
 		LoadedLatencyMs: loadedMedian,
 		LoadedJitterMs:  loadedJitter,
-	}, nil
+	}
+
+	if cfg.AdaptiveStreams {
+		result.AdaptiveStreams = true
+		result.ChosenStreams = chosenStreams
+		result.RampCurve = rampCurve
+		result.KneeStreams = kneeStreams
+	}
+
+	if pcapReport != nil {
+		retransmits, outOfOrder, rwndMin, rttSamples := pcapReport.summary()
+		result.Retransmits = retransmits
+		result.OutOfOrderSegments = outOfOrder
+		result.RwndMinBytes = rwndMin
+		result.RTTSamplesMs = rttSamples
+	}
+
+	return result, nil
+}
+
+// rampMaxDuration returns the worst-case wall-clock time rampAdaptiveStreams
+// can take: one rampStepInterval per doubling step from 1 stream up to
+// maxAdaptiveStreams, in case the ramp never saturates and runs the full
+// ladder.
+func rampMaxDuration() time.Duration {
+	steps := 0
+	for s := 1; s <= maxAdaptiveStreams; s *= 2 {
+		steps++
+	}
+	return time.Duration(steps) * rampStepInterval
+}
+
+// rampAdaptiveStreams discovers the minimum concurrency needed to saturate
+// the path: starting at 1 worker, it doubles the pool every rampStepInterval
+// until throughput fails to improve by more than rampGrowthThreshold or
+// loaded latency climbs past rampLatencyFactor times cfg.UnloadedLatencyMs
+// (when that baseline is known), then resizes the pool back down one step
+// and returns the chosen concurrency, the full ramp curve, and the knee
+// (the streams level where saturation was detected, 0 if the ramp hit
+// maxAdaptiveStreams without saturating).
+func rampAdaptiveStreams(ctx context.Context, cfg Config, pool *workerPool, totalBytes *atomic.Int64, latencyMu *sync.Mutex, loadedLatencies *[]float64) (int, []RampPoint, int) {
+	var curve []RampPoint
+	streams := 1
+	chosen := 1
+	prevMbps := 0.0
+	latencySeen := 0
+
+	lastBytes := totalBytes.Load()
+	lastTime := time.Now()
+
+	for streams <= maxAdaptiveStreams {
+		pool.resize(streams)
+
+		select {
+		case <-ctx.Done():
+			return streams, curve, streams
+		case <-time.After(rampStepInterval):
+		}
+
+		now := time.Now()
+		currentBytes := totalBytes.Load()
+		intervalSecs := now.Sub(lastTime).Seconds()
+		mbps := 0.0
+		if intervalSecs > 0.01 {
+			mbps = (float64(currentBytes-lastBytes) * 8.0 / 1_000_000.0) / intervalSecs
+		}
+		lastBytes, lastTime = currentBytes, now
+
+		latencyMu.Lock()
+		window := append([]float64(nil), (*loadedLatencies)[latencySeen:]...)
+		latencySeen = len(*loadedLatencies)
+		latencyMu.Unlock()
+		loadedMedian, _ := computeLatencyStats(window)
+
+		curve = append(curve, RampPoint{Streams: streams, Mbps: mbps, LoadedLatencyMs: loadedMedian})
+
+		saturatedByThroughput := prevMbps > 0 && mbps < prevMbps*(1+rampGrowthThreshold)
+		saturatedByLatency := cfg.UnloadedLatencyMs > 0 && loadedMedian > cfg.UnloadedLatencyMs*rampLatencyFactor
+
+		if saturatedByThroughput || saturatedByLatency {
+			knee := streams
+			chosen = streams / 2
+			if chosen < 1 {
+				chosen = 1
+			}
+			pool.resize(chosen)
+			return chosen, curve, knee
+		}
+
+		prevMbps = mbps
+		chosen = streams
+		streams *= 2
+	}
+
+	return chosen, curve, 0
 }
@@ -10,6 +10,8 @@ type Result struct {
 	Latency         *LatencyResult    `json:"latency,omitempty"`
 	Download        *ThroughputResult `json:"download,omitempty"`
 	Upload          *ThroughputResult `json:"upload,omitempty"`
+	Mesh            *MeshResult       `json:"mesh,omitempty"`
+	Servers         []ServerResult    `json:"servers,omitempty"`
 	Streams         int               `json:"streams,omitempty"`
 	DurationSeconds int               `json:"duration_seconds,omitempty"`
 	Timestamp       time.Time         `json:"timestamp"`
@@ -34,6 +36,24 @@ type ThroughputResult struct {
 	Bytes           int64   `json:"bytes"`
 	LoadedLatencyMs float64 `json:"loaded_latency_ms"`
 	LoadedJitterMs  float64 `json:"loaded_jitter_ms"`
+
+	// Populated when the test ran with AdaptiveStreams: the concurrency the
+	// ramp settled on, the points sampled along the way, and the streams
+	// level where saturation was detected (0 if the ramp never saturated).
+	AdaptiveStreams bool        `json:"adaptive_streams,omitempty"`
+	ChosenStreams   int         `json:"chosen_streams,omitempty"`
+	RampCurve       []RampPoint `json:"ramp_curve,omitempty"`
+	KneeStreams     int         `json:"knee_streams,omitempty"`
+
+	// Populated when the test ran with cfg.PcapPath set: a summary of the
+	// per-connection packet capture, distinguishing "link is slow" from
+	// "link is lossy" (Retransmits/OutOfOrderSegments) or "receiver window
+	// is capping throughput" (RwndMinBytes). The full per-connection report
+	// is written to PcapPath.
+	Retransmits        int       `json:"retransmits,omitempty"`
+	OutOfOrderSegments int       `json:"out_of_order_segments,omitempty"`
+	RwndMinBytes       int64     `json:"rwnd_min_bytes,omitempty"`
+	RTTSamplesMs       []float64 `json:"rtt_samples_ms,omitempty"`
 }
 
 // Config holds test parameters.
@@ -46,6 +66,45 @@ type Config struct {
 	UploadOnly    bool
 	Timeout       time.Duration
 	Interface     string // Network interface to bind to (e.g. "eth2")
+	BaseURL       string // Override target for worker/probe requests (e.g. a mesh peer); empty means the Cloudflare edge
+
+	// AdaptiveStreams replaces the fixed Streams worker count with a ramp
+	// that discovers the minimum concurrency needed to saturate the path.
+	// UnloadedLatencyMs is the baseline (from MeasureLatency) the ramp
+	// compares loaded latency against to detect bufferbloat; 0 disables
+	// that half of the saturation check and leaves only the throughput test.
+	AdaptiveStreams   bool
+	UnloadedLatencyMs float64
+
+	// PcapPath enables opt-in packet-capture diagnostics: traffic on
+	// Interface (or pcap's default device, if unset) is captured, reassembled
+	// per TCP flow, and a per-connection report is written to this path as
+	// JSON. Empty disables capture entirely.
+	PcapPath string
+
+	// ProgressSink, when set, is called once per 200ms sampling tick during
+	// MeasureThroughput with that interval's stats. It's a pure observer: the
+	// JSON output path is unaffected whether or not a sink is installed.
+	ProgressSink func(ProgressSample)
+
+	// SamplesOutput, when set, streams one NDJSON record per 200ms sampling
+	// tick plus one per completed HTTP request to this path, for
+	// time-series post-processing (Prometheus/Grafana, pandas, correlating
+	// 429-driven chunk-halving against throughput dips). Empty disables it;
+	// the aggregate ThroughputResult is unaffected either way.
+	SamplesOutput string
+}
+
+// ProgressSample is one 200ms tick of live progress, delivered to
+// cfg.ProgressSink.
+type ProgressSample struct {
+	Phase           string  // "download" or "upload"
+	ElapsedMs       int64
+	IntervalBytes   int64
+	TotalBytes      int64
+	Mbps            float64
+	LoadedLatencyMs float64 // 0 if no latency probe landed during this interval
+	ActiveWorkers   int
 }
 
 // DefaultConfig returns sensible defaults matching the C# service.